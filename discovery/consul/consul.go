@@ -22,6 +22,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	consul "github.com/hashicorp/consul/api"
@@ -36,8 +37,12 @@ import (
 )
 
 const (
-	watchTimeout  = 30 * time.Second
-	retryInterval = 15 * time.Second
+	watchTimeout = 30 * time.Second
+
+	// backoffMultiplier and backoffRandomizationFactor tune how aggressively
+	// failed RPCs back off; see newBackOff.
+	backoffMultiplier          = 2
+	backoffRandomizationFactor = 0.3
 
 	// addressLabel is the name for the label containing a target's address.
 	addressLabel = model.MetaLabelPrefix + "consul_address"
@@ -57,6 +62,24 @@ const (
 	datacenterLabel = model.MetaLabelPrefix + "consul_dc"
 	// serviceIDLabel is the name of the label containing the service ID.
 	serviceIDLabel = model.MetaLabelPrefix + "consul_service_id"
+	// agentIDLabel is the name of the label containing the agent ID, only set
+	// when discovering services registered on the local agent.
+	agentIDLabel = model.MetaLabelPrefix + "consul_agent_id"
+	// healthLabel is the name of the label containing the aggregated health
+	// status of the instance, one of "passing", "warning", "critical" or "maintenance".
+	healthLabel = model.MetaLabelPrefix + "consul_health"
+	// healthCheckLabel is the prefix for labels mapping to the status of an
+	// individual health check registered against the instance.
+	healthCheckLabel = model.MetaLabelPrefix + "consul_check_"
+	// connectProxyNameLabel is the name of the label containing the name of a
+	// Connect proxy service instance.
+	connectProxyNameLabel = model.MetaLabelPrefix + "consul_connect_proxy_name"
+	// connectSidecarForLabel is the name of the label containing the name of
+	// the service a Connect proxy is a sidecar for.
+	connectSidecarForLabel = model.MetaLabelPrefix + "consul_connect_sidecar_for"
+	// connectUpstreamLabel is the prefix for labels exposing the upstream
+	// services configured on a Connect proxy.
+	connectUpstreamLabel = model.MetaLabelPrefix + "consul_connect_upstream_"
 
 	// Constants for instrumentation.
 	namespace = "prometheus"
@@ -77,13 +100,24 @@ var (
 		},
 		[]string{"endpoint", "call"},
 	)
+	rpcBackoffSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "sd_consul_rpc_backoff_seconds",
+			Help:      "The backoff duration before retrying a failed Consul RPC call, in seconds.",
+		},
+		[]string{"endpoint"},
+	)
 
 	// DefaultSDConfig is the default Consul SD configuration.
 	DefaultSDConfig = SDConfig{
-		TagSeparator: ",",
-		Scheme:       "http",
-		AllowStale:   true,
+		TagSeparator:    ",",
+		Scheme:          "http",
+		AllowStale:      true,
+		MinBackoff:      model.Duration(1 * time.Second),
+		MaxBackoff:      model.Duration(2 * time.Minute),
 		RefreshInterval: model.Duration(0 * time.Second),
+		HealthStatuses:  []string{"any"},
 	}
 )
 
@@ -109,7 +143,42 @@ type SDConfig struct {
 	// Defaults to all services if empty.
 	Services []string `yaml:"services"`
 	// An optional tag used to filter instances inside a service.
+	// Deprecated: use Tags instead.
 	Tag string `yaml:"tag"`
+	// An optional list of tags used to filter instances inside a service.
+	// Services must have all of the given tags to be watched.
+	Tags []string `yaml:"tags,omitempty"`
+	// An optional Consul filter expression, evaluated server side, used to
+	// filter instances inside a service. When it is set, Tags are no longer
+	// checked client side; they are still sent to the Consul endpoints that
+	// take tags. See https://www.consul.io/api-docs/features/filtering.
+	Filter string `yaml:"filter,omitempty"`
+	// AgentOnly, if set, discovers only the services registered on the local
+	// Consul agent instead of watching the full catalog. This is cheaper on
+	// large clusters where Prometheus runs as a sidecar next to the agent.
+	AgentOnly bool `yaml:"agent_only,omitempty"`
+	// A list of health statuses used to filter instances. Valid values are
+	// "passing", "warning", "critical" and "any". Defaults to "any", which
+	// preserves the existing behavior of not filtering on health at all.
+	// Connect sidecar proxy instances are not filtered on health.
+	HealthStatuses []string `yaml:"health_statuses,omitempty"`
+	// Connect, if set, additionally discovers the Connect (service mesh)
+	// sidecar proxy instances of the watched services, alongside their
+	// regular instances.
+	Connect bool `yaml:"connect,omitempty"`
+	// MinBackoff and MaxBackoff are the initial and the largest base interval
+	// of the exponential backoff applied between retries of a failed Consul
+	// RPC call; each actual wait is jittered by up to 30% around that base.
+	// The backoff is reset as soon as a call succeeds.
+	MinBackoff model.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff model.Duration `yaml:"max_backoff,omitempty"`
+	// NodeMeta constrains the discovered instances to nodes carrying all of
+	// the given node metadata key/value pairs.
+	NodeMeta map[string]string `yaml:"node_meta,omitempty"`
+	// Nodes, if set, discovers the raw list of nodes registered in the
+	// catalog instead of service instances. Useful for discovering
+	// node-level exporters that aren't registered as Consul services.
+	Nodes bool `yaml:"nodes,omitempty"`
 
 	TLSConfig config_util.TLSConfig `yaml:"tls_config,omitempty"`
 	// Catches all undefined fields and must be empty after parsing.
@@ -130,30 +199,88 @@ func (c *SDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if strings.TrimSpace(c.Server) == "" {
 		return fmt.Errorf("Consul SD configuration requires a server address")
 	}
+	if c.Tag != "" {
+		if len(c.Tags) != 0 {
+			return fmt.Errorf("tag and tags cannot both be set in consul_sd_config")
+		}
+		c.Tags = []string{c.Tag}
+	}
+	for _, status := range c.HealthStatuses {
+		switch status {
+		case "any", "passing", "warning", "critical":
+		default:
+			return fmt.Errorf("invalid health status %q, must be one of any, passing, warning or critical", status)
+		}
+	}
+	if c.AgentOnly && c.Filter != "" {
+		// The local agent API has no equivalent of the catalog/health
+		// endpoints' Filter parameter, so this would silently watch every
+		// service registered on the agent instead of honoring Filter.
+		return fmt.Errorf("filter cannot be used together with agent_only in consul_sd_config")
+	}
+	if c.AgentOnly && len(c.NodeMeta) > 0 {
+		// agent.Services() takes no QueryOptions at all, so NodeMeta would
+		// silently go unfiltered, the same trap as Filter above.
+		return fmt.Errorf("node_meta cannot be used together with agent_only in consul_sd_config")
+	}
+	// agent_only and nodes each pick a Run loop of their own (watchAgent,
+	// watchNodes) that never looks at the other discovery modes below, so
+	// combining them would silently drop part of the configuration.
+	if c.AgentOnly && c.Nodes {
+		return fmt.Errorf("agent_only and nodes cannot both be set in consul_sd_config")
+	}
+	if c.AgentOnly && c.Connect {
+		return fmt.Errorf("connect is not supported together with agent_only in consul_sd_config")
+	}
+	if c.Nodes && c.Connect {
+		return fmt.Errorf("connect is not supported together with nodes in consul_sd_config")
+	}
+	if customHealthStatuses := len(c.HealthStatuses) != 1 || c.HealthStatuses[0] != "any"; customHealthStatuses && (c.AgentOnly || c.Nodes) {
+		return fmt.Errorf("health_statuses is not supported together with agent_only or nodes in consul_sd_config")
+	}
 	return nil
 }
 
 func init() {
 	prometheus.MustRegister(rpcFailuresCount)
 	prometheus.MustRegister(rpcDuration)
+	prometheus.MustRegister(rpcBackoffSeconds)
 
 	// Initialize metric vectors.
-	rpcDuration.WithLabelValues("catalog", "service")
+	rpcDuration.WithLabelValues("health", "service")
 	rpcDuration.WithLabelValues("catalog", "services")
+	rpcDuration.WithLabelValues("catalog", "connect")
+	rpcDuration.WithLabelValues("catalog", "nodes")
+	rpcDuration.WithLabelValues("agent", "services")
+
+	rpcBackoffSeconds.WithLabelValues("catalog")
+	rpcBackoffSeconds.WithLabelValues("agent")
+	rpcBackoffSeconds.WithLabelValues("nodes")
+	rpcBackoffSeconds.WithLabelValues("service")
+	rpcBackoffSeconds.WithLabelValues("connect")
 }
 
 // Discovery retrieves target information from a Consul server
 // and updates them via watches.
 type Discovery struct {
-	client           *consul.Client
-	clientConf       *consul.Config
-	clientDatacenter string
-	tagSeparator     string
-	watchedServices  []string // Set of services which will be discovered.
-	watchedTag       string   // A tag used to filter instances of a service.
-	allowStale       bool
-	refreshInterval  time.Duration
-	logger           log.Logger
+	client                *consul.Client
+	clientConf            *consul.Config
+	clientDatacenter      string
+	tagSeparator          string
+	watchedServices       []string          // Set of services which will be discovered.
+	watchedTags           []string          // Tags used to filter instances of a service, ANDed together.
+	watchedFilter         string            // Consul filter expression used to filter instances of a service server side.
+	watchedAgentOnly      bool              // Discover only services registered on the local agent.
+	watchedHealthStatuses []string          // Health statuses used to filter instances of a service.
+	watchedConnect        bool              // Also discover the Connect sidecar proxies of watched services.
+	watchedNodeMeta       map[string]string // Node metadata used to filter instances of a service.
+	watchedNodes          bool              // Discover raw nodes instead of service instances.
+	allowStale            bool
+	refreshInterval       time.Duration
+	minBackoff            time.Duration
+	maxBackoff            time.Duration
+	backoff               backoff.BackOff // Backoff for catalog/agent-level RPC failures.
+	logger                log.Logger
 }
 
 // NewDiscovery returns a new Discovery for the given config.
@@ -197,23 +324,43 @@ func NewDiscovery(conf *SDConfig, logger log.Logger) (*Discovery, error) {
 	if err != nil {
 		return nil, err
 	}
+	minBackoff := time.Duration(conf.MinBackoff)
+	maxBackoff := time.Duration(conf.MaxBackoff)
 	cd := &Discovery{
-		client:           client,
-		clientConf:       clientConf,
-		tagSeparator:     conf.TagSeparator,
-		watchedServices:  conf.Services,
-		watchedTag:       conf.Tag,
-		allowStale:       conf.AllowStale,
-		refreshInterval:  time.Duration(conf.RefreshInterval),
-		clientDatacenter: clientConf.Datacenter,
-		logger:           logger,
+		client:                client,
+		clientConf:            clientConf,
+		tagSeparator:          conf.TagSeparator,
+		watchedServices:       conf.Services,
+		watchedTags:           conf.Tags,
+		watchedFilter:         conf.Filter,
+		watchedAgentOnly:      conf.AgentOnly,
+		watchedHealthStatuses: conf.HealthStatuses,
+		watchedConnect:        conf.Connect,
+		watchedNodeMeta:       conf.NodeMeta,
+		watchedNodes:          conf.Nodes,
+		allowStale:            conf.AllowStale,
+		refreshInterval:       time.Duration(conf.RefreshInterval),
+		minBackoff:            minBackoff,
+		maxBackoff:            maxBackoff,
+		backoff:               newBackOff(minBackoff, maxBackoff),
+		clientDatacenter:      clientConf.Datacenter,
+		logger:                logger,
 	}
 	return cd, nil
 }
 
 // shouldWatch returns whether the service of the given name should be watched.
 func (d *Discovery) shouldWatch(name string, tags []string) bool {
-	return d.shouldWatchFromName(name) && d.shouldWatchFromTags(tags)
+	if !d.shouldWatchFromName(name) {
+		return false
+	}
+	// Filter substitutes for the tag check only: it is evaluated server
+	// side, so there's no need to duplicate that part of the work client
+	// side, but it never overrides the explicit Services allow-list above.
+	if d.watchedFilter != "" {
+		return true
+	}
+	return d.shouldWatchFromTags(tags)
 }
 
 // shouldWatch returns whether the service of the given name should be watched based on its name.
@@ -232,20 +379,64 @@ func (d *Discovery) shouldWatchFromName(name string) bool {
 }
 
 // shouldWatch returns whether the service of the given name should be watched based on its tags.
+// A service must carry every one of the configured tags to be watched.
 func (d *Discovery) shouldWatchFromTags(tags []string) bool {
 	// If there's no fixed set of watched tags, we watch everything.
-	if d.watchedTag == "" {
+	if len(d.watchedTags) == 0 {
 		return true
 	}
 
-	for _, tag := range tags {
-		if d.watchedTag == tag {
+tagOuter:
+	for _, wtag := range d.watchedTags {
+		for _, tag := range tags {
+			if wtag == tag {
+				continue tagOuter
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// shouldWatchHealth returns whether an instance with the given aggregated
+// health status should be watched, based on the configured health statuses.
+func shouldWatchHealth(status string, statuses []string) bool {
+	// If there's no fixed set of watched health statuses, we watch everything.
+	if len(statuses) == 0 {
+		return true
+	}
+
+	for _, s := range statuses {
+		if s == "any" || s == status {
 			return true
 		}
 	}
 	return false
 }
 
+// newBackOff returns an exponential backoff whose base interval starts at min
+// and grows up to max, with jitter of backoffRandomizationFactor applied on
+// top of it, that retries indefinitely until reset.
+func newBackOff(min, max time.Duration) backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = min
+	b.MaxInterval = max
+	b.Multiplier = backoffMultiplier
+	b.RandomizationFactor = backoffRandomizationFactor
+	b.MaxElapsedTime = 0 // Never stop retrying.
+	return b
+}
+
+// backoffAndRetry sleeps for the next interval of b and records it in the
+// backoff gauge under endpoint. All watch loops of the same kind (e.g. every
+// per-service watch) share one endpoint label, so the gauge holds the
+// interval most recently chosen by any of them.
+func backoffAndRetry(b backoff.BackOff, endpoint string) {
+	d := b.NextBackOff()
+	rpcBackoffSeconds.WithLabelValues(endpoint).Set(d.Seconds())
+	time.Sleep(d)
+}
+
 // Get the local datacenter if not specified.
 func (d *Discovery) getDatacenter() error {
 	// If the datacenter was not set from clientConf, let's get it from the local Consul agent
@@ -266,6 +457,18 @@ func (d *Discovery) getDatacenter() error {
 
 // Run implements the Discoverer interface.
 func (d *Discovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	if d.watchedAgentOnly {
+		// The agent endpoints only expose services registered on the local
+		// agent and are watched separately from the catalog below.
+		d.watchAgent(ctx, ch)
+		return
+	}
+	if d.watchedNodes {
+		// Nodes mode discovers the raw catalog nodes instead of services.
+		d.watchNodes(ctx, ch)
+		return
+	}
+
 	// Watched services and their cancellation functions.
 	services := map[string]func(){}
 
@@ -282,11 +485,11 @@ func (d *Discovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
 		// Get the local datacenter first, if necessary.
 		err := d.getDatacenter()
 		if err != nil {
-			time.Sleep(retryInterval)
+			backoffAndRetry(d.backoff, "catalog")
 			continue
 		}
 
-		if len(d.watchedServices) == 0 || d.watchedTag != "" {
+		if len(d.watchedServices) == 0 || len(d.watchedTags) != 0 || d.watchedFilter != "" {
 			// We need to watch the catalog.
 			d.watchServices(ctx, ch, &lastIndex, services)
 		} else {
@@ -294,6 +497,9 @@ func (d *Discovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
 			for _, name := range d.watchedServices {
 				ctx, _ := context.WithCancel(ctx)
 				d.watchService(name, ctx, ch)
+				if d.watchedConnect {
+					d.watchConnectService(name, ctx, ch)
+				}
 			}
 			// Wait for cancellation.
 			<-ctx.Done()
@@ -307,22 +513,25 @@ func (d *Discovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
 // watch nodes the service directly.
 func (d *Discovery) watchServices(ctx context.Context, ch chan<- []*targetgroup.Group, lastIndex *uint64, services map[string]func()) error {
 	catalog := d.client.Catalog()
-	level.Debug(d.logger).Log("msg", "Watching services", "tag", d.watchedTag)
+	level.Debug(d.logger).Log("msg", "Watching services", "tags", strings.Join(d.watchedTags, ","))
 
 	t0 := time.Now()
 	srvs, meta, err := catalog.Services(&consul.QueryOptions{
 		WaitIndex:  *lastIndex,
 		WaitTime:   watchTimeout,
 		AllowStale: d.allowStale,
+		Filter:     d.watchedFilter,
+		NodeMeta:   d.watchedNodeMeta,
 	})
 	rpcDuration.WithLabelValues("catalog", "services").Observe(time.Since(t0).Seconds())
 
 	if err != nil {
 		level.Error(d.logger).Log("msg", "Error refreshing service list", "err", err)
 		rpcFailuresCount.Inc()
-		time.Sleep(retryInterval)
+		backoffAndRetry(d.backoff, "catalog")
 		return err
 	}
+	d.backoff.Reset()
 	// If the index equals the previous one, the watch timed out with no update.
 	if meta.LastIndex == *lastIndex {
 		return nil
@@ -344,6 +553,9 @@ func (d *Discovery) watchServices(ctx context.Context, ch chan<- []*targetgroup.
 
 		wctx, cancel := context.WithCancel(ctx)
 		d.watchService(name, wctx, ch)
+		if d.watchedConnect {
+			d.watchConnectService(name, wctx, ch)
+		}
 		services[name] = cancel
 	}
 
@@ -354,11 +566,16 @@ func (d *Discovery) watchServices(ctx context.Context, ch chan<- []*targetgroup.
 			cancel()
 			delete(services, name)
 
-			// Send clearing target group.
+			// Send clearing target groups for both the service itself and,
+			// if enabled, its Connect sidecar proxies.
+			groups := []*targetgroup.Group{{Source: name}}
+			if d.watchedConnect {
+				groups = append(groups, &targetgroup.Group{Source: name + ":connect"})
+			}
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case ch <- []*targetgroup.Group{{Source: name}}:
+			case ch <- groups:
 			}
 		}
 	}
@@ -367,15 +584,185 @@ func (d *Discovery) watchServices(ctx context.Context, ch chan<- []*targetgroup.
 	return nil
 }
 
+// watchAgent discovers the services registered on the local Consul agent.
+// Unlike the catalog endpoints used by watchServices, the agent endpoints
+// don't support blocking queries, so we poll them at refreshInterval instead,
+// or at watchTimeout when refreshInterval is unset.
+func (d *Discovery) watchAgent(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	agent := d.client.Agent()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if err := d.getDatacenter(); err != nil {
+			backoffAndRetry(d.backoff, "agent")
+			continue
+		}
+
+		t0 := time.Now()
+		svcs, err := agent.Services()
+		rpcDuration.WithLabelValues("agent", "services").Observe(time.Since(t0).Seconds())
+
+		if err != nil {
+			level.Error(d.logger).Log("msg", "Error refreshing agent service list", "err", err)
+			rpcFailuresCount.Inc()
+			backoffAndRetry(d.backoff, "agent")
+			continue
+		}
+		d.backoff.Reset()
+
+		tgroup := targetgroup.Group{
+			Source:  "agent",
+			Targets: make([]model.LabelSet, 0, len(svcs)),
+		}
+
+		for id, svc := range svcs {
+			if !d.shouldWatch(svc.Service, svc.Tags) {
+				continue
+			}
+			tgroup.Targets = append(tgroup.Targets, d.agentServiceLabels(id, svc))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- []*targetgroup.Group{&tgroup}:
+		}
+
+		// The agent endpoints have no blocking-query support, so this sleep is
+		// the only thing throttling how often we poll it; fall back to
+		// watchTimeout when refreshInterval is unset, since its zero default
+		// is only safe for the blocking-query based watch loops.
+		interval := d.refreshInterval
+		if interval <= 0 {
+			interval = watchTimeout
+		}
+		time.Sleep(interval)
+	}
+}
+
+// agentServiceLabels builds the label set for a single service instance
+// returned by agent.Services(), keyed by its agent-local service ID.
+func (d *Discovery) agentServiceLabels(id string, svc *consul.AgentService) model.LabelSet {
+	// We surround the separated list with the separator as well. This way regular expressions
+	// in relabeling rules don't have to consider tag positions.
+	var tags = d.tagSeparator + strings.Join(svc.Tags, d.tagSeparator) + d.tagSeparator
+
+	addr := net.JoinHostPort(svc.Address, fmt.Sprintf("%d", svc.Port))
+
+	labels := model.LabelSet{
+		model.AddressLabel:  model.LabelValue(addr),
+		serviceLabel:        model.LabelValue(svc.Service),
+		serviceAddressLabel: model.LabelValue(svc.Address),
+		servicePortLabel:    model.LabelValue(strconv.Itoa(svc.Port)),
+		serviceIDLabel:      model.LabelValue(svc.ID),
+		agentIDLabel:        model.LabelValue(id),
+		tagsLabel:           model.LabelValue(tags),
+		datacenterLabel:     model.LabelValue(d.clientDatacenter),
+	}
+
+	// Add all key/value pairs from the service's metadata as their own labels.
+	for k, v := range svc.Meta {
+		name := strutil.SanitizeLabelName(k)
+		labels[metaDataLabel+model.LabelName(name)] = model.LabelValue(v)
+	}
+	return labels
+}
+
+// nodeLabels builds the label set for a single node returned by catalog.Nodes.
+func (d *Discovery) nodeLabels(node *consul.Node) model.LabelSet {
+	labels := model.LabelSet{
+		model.AddressLabel: model.LabelValue(node.Address),
+		addressLabel:       model.LabelValue(node.Address),
+		nodeLabel:          model.LabelValue(node.Node),
+		datacenterLabel:    model.LabelValue(d.clientDatacenter),
+	}
+
+	// Add all key/value pairs from the node's metadata as their own labels.
+	for k, v := range node.Meta {
+		name := strutil.SanitizeLabelName(k)
+		labels[metaDataLabel+model.LabelName(name)] = model.LabelValue(v)
+	}
+	return labels
+}
+
+// watchNodes discovers the raw list of nodes registered in the Consul
+// catalog, rather than service instances. This is useful for discovering
+// node-level exporters (e.g. node_exporter) without registering each of
+// them as a synthetic Consul service.
+func (d *Discovery) watchNodes(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	catalog := d.client.Catalog()
+
+	lastIndex := uint64(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if err := d.getDatacenter(); err != nil {
+			backoffAndRetry(d.backoff, "nodes")
+			continue
+		}
+
+		t0 := time.Now()
+		nodes, meta, err := catalog.Nodes(&consul.QueryOptions{
+			WaitIndex:  lastIndex,
+			WaitTime:   watchTimeout,
+			AllowStale: d.allowStale,
+			NodeMeta:   d.watchedNodeMeta,
+		})
+		rpcDuration.WithLabelValues("catalog", "nodes").Observe(time.Since(t0).Seconds())
+
+		if err != nil {
+			level.Error(d.logger).Log("msg", "Error refreshing node list", "err", err)
+			rpcFailuresCount.Inc()
+			backoffAndRetry(d.backoff, "nodes")
+			continue
+		}
+		d.backoff.Reset()
+		// If the index equals the previous one, the watch timed out with no update.
+		if meta.LastIndex == lastIndex {
+			continue
+		}
+		lastIndex = meta.LastIndex
+
+		tgroup := targetgroup.Group{
+			Source:  d.clientDatacenter,
+			Targets: make([]model.LabelSet, 0, len(nodes)),
+		}
+
+		for _, node := range nodes {
+			tgroup.Targets = append(tgroup.Targets, d.nodeLabels(node))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- []*targetgroup.Group{&tgroup}:
+		}
+
+		time.Sleep(d.refreshInterval)
+	}
+}
+
 // consulService contains data belonging to the same service.
 type consulService struct {
-	name         string
-	tag          string
-	labels       model.LabelSet
-	discovery    *Discovery
-	client       *consul.Client
-	tagSeparator string
-	logger       log.Logger
+	name           string
+	tags           []string
+	filter         string
+	labels         model.LabelSet
+	discovery      *Discovery
+	client         *consul.Client
+	tagSeparator   string
+	healthStatuses []string
+	backoff        backoff.BackOff
+	logger         log.Logger
 }
 
 // Start watching a service.
@@ -384,33 +771,76 @@ func (d *Discovery) watchService(name string, ctx context.Context, ch chan<- []*
 		discovery: d,
 		client:    d.client,
 		name:      name,
-		tag:       d.watchedTag,
+		tags:      d.watchedTags,
+		filter:    d.watchedFilter,
+		labels: model.LabelSet{
+			serviceLabel:    model.LabelValue(name),
+			datacenterLabel: model.LabelValue(d.clientDatacenter),
+		},
+		tagSeparator:   d.tagSeparator,
+		healthStatuses: d.watchedHealthStatuses,
+		backoff:        newBackOff(d.minBackoff, d.maxBackoff),
+		logger:         d.logger,
+	}
+
+	go srv.watch(ctx, ch)
+}
+
+// Start watching the Connect sidecar proxy instances of a service, alongside
+// its regular instances.
+func (d *Discovery) watchConnectService(name string, ctx context.Context, ch chan<- []*targetgroup.Group) {
+	srv := &consulService{
+		discovery: d,
+		client:    d.client,
+		name:      name,
+		tags:      d.watchedTags,
+		filter:    d.watchedFilter,
 		labels: model.LabelSet{
 			serviceLabel:    model.LabelValue(name),
 			datacenterLabel: model.LabelValue(d.clientDatacenter),
 		},
 		tagSeparator: d.tagSeparator,
+		backoff:      newBackOff(d.minBackoff, d.maxBackoff),
 		logger:       d.logger,
 	}
 
-	go srv.watch(ctx, ch)
+	go srv.watchConnect(ctx, ch)
 }
 
-// Continuously watch one service.
-func (srv *consulService) watch(ctx context.Context, ch chan<- []*targetgroup.Group) {
+// shouldWatchConnectNode returns whether a node returned by catalog.Connect
+// should be kept, applying the tag filter client side. catalog.Connect only
+// accepts a single tag, so with two or more configured tags we have to AND
+// them together ourselves; this is skipped when a server-side Filter is set,
+// since that already did the filtering.
+func (srv *consulService) shouldWatchConnectNode(tags []string) bool {
+	if srv.filter != "" || len(srv.tags) <= 1 {
+		return true
+	}
+	return srv.discovery.shouldWatchFromTags(tags)
+}
+
+// Continuously watch the Connect sidecar proxy instances of a service.
+func (srv *consulService) watchConnect(ctx context.Context, ch chan<- []*targetgroup.Group) {
 	catalog := srv.client.Catalog()
 
+	var tag string
+	if len(srv.tags) == 1 {
+		tag = srv.tags[0]
+	}
+
 	lastIndex := uint64(0)
 	for {
-		level.Debug(srv.logger).Log("msg", "Watching service", "service", srv.name, "tag", srv.tag)
+		level.Debug(srv.logger).Log("msg", "Watching Connect service", "service", srv.name)
 
 		t0 := time.Now()
-		nodes, meta, err := catalog.Service(srv.name, srv.tag, &consul.QueryOptions{
+		nodes, meta, err := catalog.Connect(srv.name, tag, &consul.QueryOptions{
 			WaitIndex:  lastIndex,
 			WaitTime:   watchTimeout,
 			AllowStale: srv.discovery.allowStale,
+			Filter:     srv.filter,
+			NodeMeta:   srv.discovery.watchedNodeMeta,
 		})
-		rpcDuration.WithLabelValues("catalog", "service").Observe(time.Since(t0).Seconds())
+		rpcDuration.WithLabelValues("catalog", "connect").Observe(time.Since(t0).Seconds())
 
 		// Check the context before potentially falling in a continue-loop.
 		select {
@@ -421,11 +851,12 @@ func (srv *consulService) watch(ctx context.Context, ch chan<- []*targetgroup.Gr
 		}
 
 		if err != nil {
-			level.Error(srv.logger).Log("msg", "Error refreshing service", "service", srv.name, "tag", srv.tag, "err", err)
+			level.Error(srv.logger).Log("msg", "Error refreshing Connect service", "service", srv.name, "err", err)
 			rpcFailuresCount.Inc()
-			time.Sleep(retryInterval)
+			backoffAndRetry(srv.backoff, "connect")
 			continue
 		}
+		srv.backoff.Reset()
 		// If the index equals the previous one, the watch timed out with no update.
 		if meta.LastIndex == lastIndex {
 			continue
@@ -433,12 +864,15 @@ func (srv *consulService) watch(ctx context.Context, ch chan<- []*targetgroup.Gr
 		lastIndex = meta.LastIndex
 
 		tgroup := targetgroup.Group{
-			Source:  srv.name,
+			Source:  srv.name + ":connect",
 			Labels:  srv.labels,
 			Targets: make([]model.LabelSet, 0, len(nodes)),
 		}
 
 		for _, node := range nodes {
+			if !srv.shouldWatchConnectNode(node.ServiceTags) {
+				continue
+			}
 
 			// We surround the separated list with the separator as well. This way regular expressions
 			// in relabeling rules don't have to consider tag positions.
@@ -453,22 +887,156 @@ func (srv *consulService) watch(ctx context.Context, ch chan<- []*targetgroup.Gr
 				addr = net.JoinHostPort(node.Address, fmt.Sprintf("%d", node.ServicePort))
 			}
 
+			labels := model.LabelSet{
+				model.AddressLabel:    model.LabelValue(addr),
+				addressLabel:          model.LabelValue(node.Address),
+				nodeLabel:             model.LabelValue(node.Node),
+				tagsLabel:             model.LabelValue(tags),
+				serviceAddressLabel:   model.LabelValue(node.ServiceAddress),
+				servicePortLabel:      model.LabelValue(strconv.Itoa(node.ServicePort)),
+				serviceIDLabel:        model.LabelValue(node.ServiceID),
+				connectProxyNameLabel: model.LabelValue(node.ServiceName),
+			}
+
+			// Add all key/value pairs from the node's metadata as their own labels
+			for k, v := range node.NodeMeta {
+				name := strutil.SanitizeLabelName(k)
+				labels[metaDataLabel+model.LabelName(name)] = model.LabelValue(v)
+			}
+
+			if proxy := node.ServiceProxy; proxy != nil {
+				labels[connectSidecarForLabel] = model.LabelValue(proxy.DestinationServiceName)
+				for _, upstream := range proxy.Upstreams {
+					name := strutil.SanitizeLabelName(upstream.DestinationName)
+					labels[connectUpstreamLabel+model.LabelName(name)] = model.LabelValue(strconv.Itoa(upstream.LocalBindPort))
+				}
+			}
+
+			tgroup.Targets = append(tgroup.Targets, labels)
+		}
+		// Check context twice to ensure we always catch cancellation.
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- []*targetgroup.Group{&tgroup}:
+		}
+		time.Sleep(srv.discovery.refreshInterval)
+	}
+}
+
+// Continuously watch one service.
+func (srv *consulService) watch(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	health := srv.client.Health()
+
+	// health.Service can filter out everything but passing checks on the
+	// server side; any other combination of statuses is filtered client side
+	// below, based on the aggregated status of each entry.
+	passingOnly := len(srv.healthStatuses) == 1 && srv.healthStatuses[0] == "passing"
+
+	lastIndex := uint64(0)
+	for {
+		level.Debug(srv.logger).Log("msg", "Watching service", "service", srv.name, "tags", strings.Join(srv.tags, ","))
+
+		opts := &consul.QueryOptions{
+			WaitIndex:  lastIndex,
+			WaitTime:   watchTimeout,
+			AllowStale: srv.discovery.allowStale,
+			Filter:     srv.filter,
+			NodeMeta:   srv.discovery.watchedNodeMeta,
+		}
+
+		t0 := time.Now()
+		var entries []*consul.ServiceEntry
+		var meta *consul.QueryMeta
+		var err error
+		if len(srv.tags) > 1 {
+			entries, meta, err = health.ServiceMultipleTags(srv.name, srv.tags, passingOnly, opts)
+		} else {
+			var tag string
+			if len(srv.tags) == 1 {
+				tag = srv.tags[0]
+			}
+			entries, meta, err = health.Service(srv.name, tag, passingOnly, opts)
+		}
+		rpcDuration.WithLabelValues("health", "service").Observe(time.Since(t0).Seconds())
+
+		// Check the context before potentially falling in a continue-loop.
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			// Continue.
+		}
+
+		if err != nil {
+			level.Error(srv.logger).Log("msg", "Error refreshing service", "service", srv.name, "tags", strings.Join(srv.tags, ","), "err", err)
+			rpcFailuresCount.Inc()
+			backoffAndRetry(srv.backoff, "service")
+			continue
+		}
+		srv.backoff.Reset()
+		// If the index equals the previous one, the watch timed out with no update.
+		if meta.LastIndex == lastIndex {
+			continue
+		}
+		lastIndex = meta.LastIndex
+
+		tgroup := targetgroup.Group{
+			Source:  srv.name,
+			Labels:  srv.labels,
+			Targets: make([]model.LabelSet, 0, len(entries)),
+		}
+
+		for _, entry := range entries {
+			status := entry.Checks.AggregatedStatus()
+			if !shouldWatchHealth(status, srv.healthStatuses) {
+				continue
+			}
+
+			node := entry.Node
+			service := entry.Service
+
+			// We surround the separated list with the separator as well. This way regular expressions
+			// in relabeling rules don't have to consider tag positions.
+			var tags = srv.tagSeparator + strings.Join(service.Tags, srv.tagSeparator) + srv.tagSeparator
+
+			// If the service address is not empty it should be used instead of the node address
+			// since the service may be registered remotely through a different node
+			var addr string
+			if service.Address != "" {
+				addr = net.JoinHostPort(service.Address, fmt.Sprintf("%d", service.Port))
+			} else {
+				addr = net.JoinHostPort(node.Address, fmt.Sprintf("%d", service.Port))
+			}
+
 			labels := model.LabelSet{
 				model.AddressLabel:  model.LabelValue(addr),
 				addressLabel:        model.LabelValue(node.Address),
 				nodeLabel:           model.LabelValue(node.Node),
 				tagsLabel:           model.LabelValue(tags),
-				serviceAddressLabel: model.LabelValue(node.ServiceAddress),
-				servicePortLabel:    model.LabelValue(strconv.Itoa(node.ServicePort)),
-				serviceIDLabel:      model.LabelValue(node.ServiceID),
+				serviceAddressLabel: model.LabelValue(service.Address),
+				servicePortLabel:    model.LabelValue(strconv.Itoa(service.Port)),
+				serviceIDLabel:      model.LabelValue(service.ID),
+				healthLabel:         model.LabelValue(status),
 			}
 
 			// Add all key/value pairs from the node's metadata as their own labels
-			for k, v := range node.NodeMeta {
+			for k, v := range node.Meta {
 				name := strutil.SanitizeLabelName(k)
 				labels[metaDataLabel+model.LabelName(name)] = model.LabelValue(v)
 			}
 
+			// Expose the status of each individual health check under its own label.
+			for _, check := range entry.Checks {
+				name := strutil.SanitizeLabelName(check.Name)
+				labels[healthCheckLabel+model.LabelName(name)] = model.LabelValue(check.Status)
+			}
+
 			tgroup.Targets = append(tgroup.Targets, labels)
 		}
 		// Check context twice to ensure we always catch cancellation.
@@ -0,0 +1,223 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldWatchHealth(t *testing.T) {
+	cases := []struct {
+		status   string
+		statuses []string
+		expected bool
+	}{
+		{
+			status:   "passing",
+			statuses: nil,
+			expected: true,
+		},
+		{
+			status:   "critical",
+			statuses: nil,
+			expected: true,
+		},
+		{
+			status:   "passing",
+			statuses: []string{"any"},
+			expected: true,
+		},
+		{
+			status:   "passing",
+			statuses: []string{"passing"},
+			expected: true,
+		},
+		{
+			status:   "critical",
+			statuses: []string{"passing"},
+			expected: false,
+		},
+		{
+			status:   "warning",
+			statuses: []string{"passing", "warning"},
+			expected: true,
+		},
+		{
+			status:   "critical",
+			statuses: []string{"passing", "warning"},
+			expected: false,
+		},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, shouldWatchHealth(c.status, c.statuses))
+	}
+}
+
+func TestShouldWatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		tags     []string
+		d        *Discovery
+		expected bool
+	}{
+		{
+			name:     "no services, no tags, no filter",
+			tags:     []string{"tag1"},
+			d:        &Discovery{},
+			expected: true,
+		},
+		{
+			name:     "service allow-list match",
+			tags:     nil,
+			d:        &Discovery{watchedServices: []string{"name"}},
+			expected: true,
+		},
+		{
+			name:     "service allow-list mismatch",
+			tags:     nil,
+			d:        &Discovery{watchedServices: []string{"other"}},
+			expected: false,
+		},
+		{
+			name:     "tag match",
+			tags:     []string{"tag1", "tag2"},
+			d:        &Discovery{watchedTags: []string{"tag1"}},
+			expected: true,
+		},
+		{
+			name:     "tag mismatch",
+			tags:     []string{"tag2"},
+			d:        &Discovery{watchedTags: []string{"tag1"}},
+			expected: false,
+		},
+		{
+			name:     "filter does not override the service allow-list",
+			tags:     nil,
+			d:        &Discovery{watchedServices: []string{"other"}, watchedFilter: "Checks.ServiceTags contains tag1"},
+			expected: false,
+		},
+		{
+			name:     "filter substitutes for the tag check once the name matches",
+			tags:     []string{"tag2"},
+			d:        &Discovery{watchedServices: []string{"name"}, watchedTags: []string{"tag1"}, watchedFilter: "Checks.ServiceTags contains tag1"},
+			expected: true,
+		},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, c.d.shouldWatch("name", c.tags), c.name)
+	}
+}
+
+func TestShouldWatchConnectNode(t *testing.T) {
+	cases := []struct {
+		name     string
+		tags     []string
+		srv      *consulService
+		expected bool
+	}{
+		{
+			name:     "single tag is already applied server side by catalog.Connect",
+			tags:     []string{"tag2"},
+			srv:      &consulService{tags: []string{"tag1"}, discovery: &Discovery{}},
+			expected: true,
+		},
+		{
+			name:     "multiple tags match client side",
+			tags:     []string{"tag1", "tag2"},
+			srv:      &consulService{tags: []string{"tag1", "tag2"}, discovery: &Discovery{watchedTags: []string{"tag1", "tag2"}}},
+			expected: true,
+		},
+		{
+			name:     "multiple tags mismatch client side",
+			tags:     []string{"tag1"},
+			srv:      &consulService{tags: []string{"tag1", "tag2"}, discovery: &Discovery{watchedTags: []string{"tag1", "tag2"}}},
+			expected: false,
+		},
+		{
+			name:     "a server-side filter disables the client-side tag check",
+			tags:     []string{"tag1"},
+			srv:      &consulService{tags: []string{"tag1", "tag2"}, filter: `Checks.ServiceTags contains "tag1"`, discovery: &Discovery{watchedTags: []string{"tag1", "tag2"}}},
+			expected: true,
+		},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, c.srv.shouldWatchConnectNode(c.tags), c.name)
+	}
+}
+
+func TestNewBackOff(t *testing.T) {
+	min := 1 * time.Second
+	max := 2 * time.Minute
+
+	b := newBackOff(min, max)
+
+	// Jitter means intervals aren't exact, but the backoff should settle
+	// around max, never stop on its own, and never grow unbounded.
+	for i := 0; i < 100; i++ {
+		d := b.NextBackOff()
+		require.NotEqual(t, backoff.Stop, d)
+		require.Less(t, d, 2*max)
+	}
+}
+
+func TestNodeLabels(t *testing.T) {
+	d := &Discovery{clientDatacenter: "dc1"}
+	node := &consul.Node{
+		Node:    "node1",
+		Address: "1.2.3.4",
+		Meta:    map[string]string{"rack_name": "2304"},
+	}
+
+	labels := d.nodeLabels(node)
+
+	require.Equal(t, model.LabelSet{
+		model.AddressLabel:          "1.2.3.4",
+		addressLabel:                "1.2.3.4",
+		nodeLabel:                   "node1",
+		datacenterLabel:             "dc1",
+		metaDataLabel + "rack_name": "2304",
+	}, labels)
+}
+
+func TestAgentServiceLabels(t *testing.T) {
+	d := &Discovery{clientDatacenter: "dc1", tagSeparator: ","}
+	svc := &consul.AgentService{
+		ID:      "redis1",
+		Service: "redis",
+		Tags:    []string{"master", "v1"},
+		Address: "10.1.2.3",
+		Port:    8000,
+		Meta:    map[string]string{"rack_name": "2304"},
+	}
+
+	labels := d.agentServiceLabels("redis1", svc)
+
+	require.Equal(t, model.LabelSet{
+		model.AddressLabel:          "10.1.2.3:8000",
+		serviceLabel:                "redis",
+		serviceAddressLabel:         "10.1.2.3",
+		servicePortLabel:            "8000",
+		serviceIDLabel:              "redis1",
+		agentIDLabel:                "redis1",
+		tagsLabel:                   ",master,v1,",
+		datacenterLabel:             "dc1",
+		metaDataLabel + "rack_name": "2304",
+	}, labels)
+}